@@ -0,0 +1,95 @@
+package soap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+)
+
+const namespaceWSAddressing = "http://www.w3.org/2005/08/addressing"
+
+// messageID is the WS-Addressing <wsa:MessageID> header element.
+type messageID struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/08/addressing MessageID"`
+	Value   string   `xml:",chardata"`
+}
+
+// addressingTo is the WS-Addressing <wsa:To> header element.
+type addressingTo struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/08/addressing To"`
+	Value   string   `xml:",chardata"`
+}
+
+// addressingAction is the WS-Addressing <wsa:Action> header element.
+type addressingAction struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/08/addressing Action"`
+	Value   string   `xml:",chardata"`
+}
+
+// ReplyTo is the WS-Addressing <wsa:ReplyTo> header element.
+type ReplyTo struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/08/addressing ReplyTo"`
+	Address string   `xml:"http://www.w3.org/2005/08/addressing Address"`
+}
+
+// RelatesTo is the WS-Addressing <wsa:RelatesTo> header element, correlating
+// a response with the MessageID of the request it answers.
+type RelatesTo struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/08/addressing RelatesTo"`
+	Value   string   `xml:",chardata"`
+}
+
+// wsaHeaderProbe reads just enough of an inbound envelope's header to route
+// and correlate on WS-Addressing, without requiring the caller to parse the
+// whole header block.
+type wsaHeaderProbe struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Header  struct {
+		XMLName   xml.Name `xml:"Header"`
+		Action    string   `xml:"http://www.w3.org/2005/08/addressing Action"`
+		MessageID string   `xml:"http://www.w3.org/2005/08/addressing MessageID"`
+		To        string   `xml:"http://www.w3.org/2005/08/addressing To"`
+		ReplyTo   struct {
+			Address string `xml:"http://www.w3.org/2005/08/addressing Address"`
+		} `xml:"http://www.w3.org/2005/08/addressing ReplyTo"`
+		RelatesTo string `xml:"http://www.w3.org/2005/08/addressing RelatesTo"`
+	}
+}
+
+// Addressing holds the WS-Addressing header values read off an inbound
+// request. Server.ServeHTTP attaches one to the request context when
+// Server.UseWSAddressing(true) is in effect; retrieve it with
+// AddressingFromContext.
+type Addressing struct {
+	MessageID string
+	To        string
+	ReplyTo   string
+	RelatesTo string
+}
+
+type addressingContextKey struct{}
+
+// AddressingFromContext returns the Addressing read from the inbound
+// request's WS-Addressing header, if Server.UseWSAddressing(true) is in
+// effect and the request carried one.
+func AddressingFromContext(ctx context.Context) (Addressing, bool) {
+	addressing, ok := ctx.Value(addressingContextKey{}).(Addressing)
+	return addressing, ok
+}
+
+// newMessageID returns a fresh urn:uuid: MessageID, per the WS-Addressing
+// convention.
+func newMessageID() string {
+	return "urn:uuid:" + newUUID()
+}
+
+// newUUID generates a random (version 4) UUID without pulling in an external
+// dependency.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,91 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUUID(t *testing.T) {
+	uuidRE := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	assert.Regexp(t, uuidRE, newUUID())
+	assert.NotEqual(t, newUUID(), newUUID())
+
+	assert.Regexp(t, "^urn:uuid:"+uuidRE.String()[1:], newMessageID())
+}
+
+func TestAddressingFromContext(t *testing.T) {
+	_, ok := AddressingFromContext(context.Background())
+	assert.False(t, ok)
+
+	want := Addressing{MessageID: "urn:uuid:1", To: "http://example.com", ReplyTo: "http://example.com/reply", RelatesTo: "urn:uuid:0"}
+	ctx := context.WithValue(context.Background(), addressingContextKey{}, want)
+	have, ok := AddressingFromContext(ctx)
+	require.True(t, ok)
+	assert.Exactly(t, want, have)
+}
+
+func TestServer_ServeHTTP_WSAddressing(t *testing.T) {
+	soapSrv := NewServer()
+	soapSrv.UseSoap12()
+	soapSrv.UseWSAddressing(true)
+
+	var gotAddressing Addressing
+	soapSrv.RegisterHandler(
+		"/pathTo",
+		"testPostAction",
+		"fooRequest",
+		func() interface{} { return &FooRequest{} },
+		func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			gotAddressing, _ = AddressingFromContext(ctx)
+			fooRequest := request.(*FooRequest)
+			return &FooResponse{Bar: "Hello " + fooRequest.Foo}, nil
+		},
+	)
+	srv := httptest.NewServer(soapSrv)
+	defer srv.Close()
+
+	// No SOAPAction header - routing must fall back to wsa:Action, as SOAP
+	// 1.2 permits.
+	requestBody := []byte(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://www.w3.org/2005/08/addressing">
+    <soap:Header>
+        <wsa:MessageID>urn:uuid:request-1</wsa:MessageID>
+        <wsa:Action>testPostAction</wsa:Action>
+        <wsa:To>http://example.com/pathTo</wsa:To>
+    </soap:Header>
+    <soap:Body>
+        <fooRequest><Foo>wsa</Foo></fooRequest>
+    </soap:Body>
+</soap:Envelope>`)
+
+	req, err := http.NewRequest("POST", srv.URL+"/pathTo", bytes.NewReader(requestBody))
+	require.NoError(t, err)
+	req.Header.Add("Content-Type", SoapContentType12)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Exactly(t, "urn:uuid:request-1", gotAddressing.MessageID)
+	assert.Exactly(t, "http://example.com/pathTo", gotAddressing.To)
+
+	var responseEnvelope struct {
+		Header struct {
+			RelatesTo string `xml:"http://www.w3.org/2005/08/addressing RelatesTo"`
+		}
+		Body Body
+	}
+	responseEnvelope.Body = Body{Content: &FooResponse{}}
+	require.NoError(t, xml.Unmarshal(respBody, &responseEnvelope))
+	assert.Exactly(t, "urn:uuid:request-1", responseEnvelope.Header.RelatesTo)
+	assert.Exactly(t, "Hello wsa", responseEnvelope.Body.Content.(*FooResponse).Bar)
+}
@@ -2,6 +2,7 @@ package soap
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -49,6 +50,23 @@ type Client struct {
 	ContentType    string
 	SoapVersion    string
 	HTTPClientDoFn func(req *http.Request) (*http.Response, error)
+
+	// TypeFunc resolves the response struct type from the first element's
+	// xml.Name, so a single call site can handle an operation with more than
+	// one possible response shape. CallTyped uses it and returns the decoded
+	// value; Call/CallContext also use it when response is passed as nil, but
+	// do not return the decoded value. See Body.TypeFunc.
+	TypeFunc func(name xml.Name) (reflect.Type, bool)
+
+	// SecurityOptions builds the <wsse:Security> header attached to every
+	// call. See WithUsernameTokenDigest, WithTimestamp, WithBinarySecurityToken.
+	SecurityOptions []SecurityOption
+
+	// WSAddressing, when true, adds a WS-Addressing header block
+	// (http://www.w3.org/2005/08/addressing) to every call: a generated
+	// MessageID, To defaulting to the endpoint URL, and Action defaulting to
+	// the call's soapAction.
+	WSAddressing bool
 }
 
 // NewClient constructor. SOAP 1.1 is used by default. Switch to SOAP 1.2 with
@@ -76,24 +94,75 @@ func (c *Client) UseSoap12() {
 	c.ContentType = SoapContentType12
 }
 
-// Call makes a SOAP call
+// UseWSAddressing toggles WS-Addressing on every call, for symmetry with
+// Server.UseWSAddressing.
+func (c *Client) UseWSAddressing(enabled bool) {
+	c.WSAddressing = enabled
+}
+
+// Call makes a SOAP call. It is a thin wrapper around CallContext using
+// context.Background().
 func (c *Client) Call(soapAction string, request, response interface{}) (*http.Response, error) {
-	envelope := Envelope{
-		Body: Body{Content: request},
+	return c.CallContext(context.Background(), soapAction, request, response)
+}
+
+// CallContext makes a SOAP call bound to ctx: cancellation or a deadline on
+// ctx aborts the underlying HTTP request.
+func (c *Client) CallContext(ctx context.Context, soapAction string, request, response interface{}) (*http.Response, error) {
+	body := Body{Content: &dummyContent{}} // must be a pointer in dummyContent
+	if response != nil {
+		body = Body{Content: response}
+	} else if c.TypeFunc != nil {
+		body = Body{TypeFunc: c.TypeFunc}
+	}
+	httpResponse, _, err := c.call(ctx, soapAction, request, body)
+	return httpResponse, err
+}
+
+// CallTyped is like CallContext, but resolves the response type through
+// c.TypeFunc instead of requiring a preallocated response value - useful for
+// operations that can return more than one response shape.
+func (c *Client) CallTyped(ctx context.Context, soapAction string, request interface{}) (interface{}, *http.Response, error) {
+	httpResponse, decoded, err := c.call(ctx, soapAction, request, Body{TypeFunc: c.TypeFunc})
+	return decoded, httpResponse, err
+}
+
+// buildCallHeaders adds the SecurityOptions and WSAddressing header blocks
+// configured on c to envelope for an outgoing call with the given
+// soapAction - shared by call and CallMTOMContext so the two call paths
+// can't drift apart the way CallMTOMContext once did, shipping requests with
+// neither header attached (see commit b89fa20).
+func (c *Client) buildCallHeaders(envelope SOAPEnvelope, soapAction string) {
+	if len(c.SecurityOptions) > 0 {
+		sec := &Security{MustUnderstand: "1"}
+		for _, opt := range c.SecurityOptions {
+			opt(sec)
+		}
+		envelope.AddHeader(sec)
 	}
 
+	if c.WSAddressing {
+		envelope.AddHeader(
+			&messageID{Value: newMessageID()},
+			&addressingTo{Value: c.url},
+			&addressingAction{Value: soapAction},
+		)
+	}
+}
+
+func (c *Client) call(ctx context.Context, soapAction string, request interface{}, responseBody Body) (*http.Response, interface{}, error) {
+	envelope := NewEnvelope(c.SoapVersion)
+	envelope.SetContent(request)
+	c.buildCallHeaders(envelope, soapAction)
+
 	xmlBytes, err := c.Marshaller.Marshal(envelope)
 	if err != nil {
-		return nil, err
-	}
-	// Adjust namespaces for SOAP 1.2
-	if c.SoapVersion == SoapVersion12 {
-		xmlBytes = replaceSoap11to12(xmlBytes)
+		return nil, nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.url, bytes.NewReader(xmlBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(xmlBytes))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if c.auth != nil {
 		req.SetBasicAuth(c.auth.Login, c.auth.Password)
@@ -113,7 +182,7 @@ func (c *Client) Call(soapAction string, request, response interface{}) (*http.R
 	c.Log("Header", req.Header)
 	httpResponse, err := c.HTTPClientDoFn(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer httpResponse.Body.Close()
 
@@ -135,11 +204,11 @@ func (c *Client) Call(soapAction string, request, response interface{}) (*http.R
 				break
 			}
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			slurp, err := ioutil.ReadAll(p)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if bytes.HasPrefix(slurp, soapPrefixTagLC) || bytes.HasPrefix(slurp, soapPrefixTagUC) {
 				rawBody = slurp
@@ -148,22 +217,22 @@ func (c *Client) Call(soapAction string, request, response interface{}) (*http.R
 			}
 		}
 		if !foundSoap {
-			return nil, errors.New("multipart message does contain a soapy part")
+			return nil, nil, errors.New("multipart message does contain a soapy part")
 		}
 	} else { // SINGLE PART MESSAGE
 		rawBody, err = ioutil.ReadAll(httpResponse.Body)
 		if err != nil {
-			return httpResponse, err // return both
+			return httpResponse, nil, err // return both
 		}
 		// Check if there is a body and if yes if it's a soapy one.
 		if len(rawBody) == 0 {
 			c.Log("INFO: Response Body is empty!")
-			return httpResponse, nil // Empty responses are ok. Sometimes Sometimes only a Status 200 or 202 comes back
+			return httpResponse, nil, nil // Empty responses are ok. Sometimes Sometimes only a Status 200 or 202 comes back
 		}
 		// There is a message body, but it's not SOAP. We cannot handle this!
 		if !(bytes.Contains(rawBody, soapPrefixTagLC) || bytes.Contains(rawBody, soapPrefixTagUC)) {
 			c.Log("This is not a SOAP-Message: \n", rawBody)
-			return nil, errors.New("This is not a SOAP-Message: \n" + string(rawBody))
+			return nil, nil, errors.New("This is not a SOAP-Message: \n" + string(rawBody))
 		}
 		c.Log("RAWBODY\n", rawBody)
 	}
@@ -171,30 +240,17 @@ func (c *Client) Call(soapAction string, request, response interface{}) (*http.R
 	// We have an empty body or a SOAP body
 	c.Log("\n\n## Response body:\n", rawBody)
 
-	// Our structs for Envelope, Header, Body and Fault are tagged with namespace
-	// for SOAP 1.1. Therefore we must adjust namespaces for incoming SOAP 1.2
-	// messages
-	rawBody = replaceSoap12to11(rawBody)
-
-	respEnvelope := new(Envelope)
-	// Response struct may be nil, e.g. if only a Status 200 is expected. In this
-	// case, we need a Dummy response to avoid a nil pointer if we receive a
-	// SOAP-Fault instead of the empty message (unmarshalling would fail).
-	if response == nil {
-		respEnvelope.Body = Body{Content: &dummyContent{}} // must be a pointer in dummyContent
-	} else {
-		respEnvelope.Body = Body{Content: response}
-	}
+	respEnvelope := &inboundEnvelope{Body: responseBody}
 	if err := xml.Unmarshal(rawBody, respEnvelope); err != nil {
-		return nil, fmt.Errorf("soap/client.go Call(): COULD NOT UNMARSHAL: %s\n", err)
+		return nil, nil, fmt.Errorf("soap/client.go Call(): COULD NOT UNMARSHAL: %s\n", err)
 	}
 
 	// If a SOAP Fault is received, try to jsonMarshal it and return it via the
 	// error.
 	if fault := respEnvelope.Body.Fault; fault != nil {
-		return nil, errors.New("SOAP FAULT:\n" + formatFaultXML(rawBody, 1))
+		return nil, nil, errors.New("SOAP FAULT:\n" + formatFaultXML(rawBody, 1))
 	}
-	return httpResponse, nil
+	return httpResponse, respEnvelope.DecodedBody(), nil
 }
 
 // Format the Soap Fault as indented string. Namespaces are dropped for better
@@ -274,11 +330,3 @@ var (
 	soapPrefixTagUC = []byte("<SOAP")
 	soapPrefixTagLC = []byte("<soap")
 )
-
-func replaceSoap12to11(data []byte) []byte {
-	return bytes.ReplaceAll(data, bNamespaceSoap12, bNamespaceSoap11)
-}
-
-func replaceSoap11to12(data []byte) []byte {
-	return bytes.ReplaceAll(data, bNamespaceSoap11, bNamespaceSoap12)
-}
@@ -26,7 +26,7 @@ type FooResponse struct {
 func TestClient_Call(t *testing.T) {
 	wantSOAPBody := []byte(`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/">
 	<Header xmlns="http://schemas.xmlsoap.org/soap/envelope/"></Header>
-	<Body xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+	<Body>
 		<fooRequest>
 			<Foo>hello world</Foo>
 		</fooRequest>
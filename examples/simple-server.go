@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"log"
@@ -33,7 +34,7 @@ func RunServer() {
 			return &FooRequest{}
 		},
 		// OperationHandlerFunc - do something
-		func(request interface{}, w http.ResponseWriter, httpRequest *http.Request) (response interface{}, err error) {
+		func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (response interface{}, err error) {
 			fooRequest := request.(*FooRequest)
 			fooResponse := &FooResponse{
 				Bar: "Hello \"" + fooRequest.Foo + "\"",
@@ -0,0 +1,128 @@
+package soap
+
+import "encoding/xml"
+
+// SOAPFaultError lets an error control exactly how handleError renders it as
+// a SOAP fault, instead of getting the default bare Reason/faultstring.
+// Implement it directly on an error type, or translate arbitrary errors into
+// one via Server.FaultMapper.
+type SOAPFaultError interface {
+	error
+
+	// FaultCode is the fault code in either vocabulary - SOAP 1.1's
+	// "Client"/"Server" or SOAP 1.2's "Sender"/"Receiver" ("VersionMismatch"
+	// and "MustUnderstand" are spelled the same in both). handleError
+	// normalizes it to whichever vocabulary the response's SOAP version
+	// uses (see normalizeFaultCode), so a SOAPFaultError doesn't need to
+	// know which version it'll end up being rendered as. Empty falls back
+	// to handleError's default ("" for 1.1, "Receiver" for 1.2).
+	FaultCode() string
+	// FaultSubcodes refines FaultCode for SOAP 1.2, nested in the order
+	// given (each one wrapping the next, per the WS-I spec); ignored for
+	// SOAP 1.1.
+	FaultSubcodes() []xml.Name
+	// FaultReason is the human-readable faultstring / Reason Text.
+	FaultReason() string
+	// FaultDetail is marshaled into the fault's detail/Detail element, or
+	// omitted entirely if nil.
+	FaultDetail() interface{}
+	// FaultActor is the SOAP 1.1 faultactor; ignored for SOAP 1.2.
+	FaultActor() string
+}
+
+// toSOAPFaultError returns err as a SOAPFaultError: itself if it already is
+// one, s.FaultMapper's translation if that's set and maps it to one, or a
+// minimal one that just carries err.Error() as the Reason - matching
+// handleError's behaviour before SOAPFaultError existed.
+func (s *Server) toSOAPFaultError(err error) SOAPFaultError {
+	if faultErr, ok := err.(SOAPFaultError); ok {
+		return faultErr
+	}
+	if s.FaultMapper != nil {
+		if faultErr := s.FaultMapper(err); faultErr != nil {
+			return faultErr
+		}
+	}
+	return defaultSOAPFaultError{err}
+}
+
+type defaultSOAPFaultError struct{ error }
+
+func (defaultSOAPFaultError) FaultCode() string         { return "" }
+func (defaultSOAPFaultError) FaultSubcodes() []xml.Name { return nil }
+func (e defaultSOAPFaultError) FaultReason() string     { return e.Error() }
+func (defaultSOAPFaultError) FaultDetail() interface{}  { return nil }
+func (defaultSOAPFaultError) FaultActor() string        { return "" }
+
+// normalizeFaultCode maps code between SOAP 1.1's Client/Server and SOAP
+// 1.2's Sender/Receiver vocabularies to whichever one soapVersion uses,
+// leaving VersionMismatch, MustUnderstand, an already-correct code, or an
+// unrecognized one untouched. A single Server can serve both SOAP versions
+// on the same path (see detectSoapVersion), so a SOAPFaultError's code -
+// fixed at the point it's constructed - can't be correct for both without
+// this: it lets FaultCode() always use one vocabulary and still render a
+// legal faultcode/Code Value in whichever version the request came in as.
+func normalizeFaultCode(code, soapVersion string) string {
+	if soapVersion == SoapVersion12 {
+		switch code {
+		case "Client":
+			return "Sender"
+		case "Server":
+			return "Receiver"
+		}
+		return code
+	}
+	switch code {
+	case "Sender":
+		return "Client"
+	case "Receiver":
+		return "Server"
+	}
+	return code
+}
+
+// chainFault12Subcodes nests names into a nested Fault12Subcode chain, each
+// one wrapping the next, per the WS-I SOAP 1.2 fault subcode spec.
+func chainFault12Subcodes(names []xml.Name) *Fault12Subcode {
+	if len(names) == 0 {
+		return nil
+	}
+	subcode := &Fault12Subcode{Value: names[0].Local}
+	subcode.Subcode = chainFault12Subcodes(names[1:])
+	return subcode
+}
+
+// marshalFaultDetail marshals detail (see SOAPFaultError.FaultDetail) into a
+// FaultDetail, or returns nil if detail is nil or fails to marshal.
+func marshalFaultDetail(detail interface{}) *FaultDetail {
+	if detail == nil {
+		return nil
+	}
+	rawXML, err := xml.Marshal(detail)
+	if err != nil {
+		return nil
+	}
+	return &FaultDetail{RawXML: rawXML}
+}
+
+// simpleFaultError is a minimal SOAPFaultError for callers - middleware,
+// handlers - that just need to fail with a given code/reason without
+// defining their own type. See NewFaultError.
+type simpleFaultError struct {
+	code   string
+	reason string
+}
+
+func (e *simpleFaultError) Error() string             { return e.reason }
+func (e *simpleFaultError) FaultCode() string         { return e.code }
+func (e *simpleFaultError) FaultSubcodes() []xml.Name { return nil }
+func (e *simpleFaultError) FaultReason() string       { return e.reason }
+func (e *simpleFaultError) FaultDetail() interface{}  { return nil }
+func (e *simpleFaultError) FaultActor() string        { return "" }
+
+// NewFaultError builds a SOAPFaultError with the given fault code (e.g.
+// "Sender"/"Client" or "Receiver"/"Server" - handleError defaults an empty
+// code appropriately per SOAP version, see Server.handleError) and reason.
+func NewFaultError(code, reason string) SOAPFaultError {
+	return &simpleFaultError{code: code, reason: reason}
+}
@@ -0,0 +1,189 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// Middleware wraps an OperationHandlerFunc with cross-cutting behaviour -
+// auth, rate limiting, tracing, panic recovery - without forking ServeHTTP.
+// See Server.Use for middleware applied to every operation, and
+// RegisterHandler's variadic parameter for middleware applied to just one.
+type Middleware func(next OperationHandlerFunc) OperationHandlerFunc
+
+// Use registers global middleware, run for every operation on every path,
+// ahead of any middleware RegisterHandler registered for that operation.
+// Middleware is applied in the order given: the first one wraps all the
+// others, so it's the first to see the request and the last to see the
+// response/error. This function must not be called after the server has
+// been started.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// chainMiddleware wraps handler with middleware, outermost first, i.e.
+// middleware[0] is the first to run and the last to return.
+func chainMiddleware(handler OperationHandlerFunc, middleware []Middleware) OperationHandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+type rawRequestContextKey struct{}
+
+// RawRequestFromContext returns the raw, undecoded SOAP request body
+// ServeHTTP read off the wire - useful for middleware, such as
+// WSUsernameTokenValidator, that needs to look at a header Body.UnmarshalXML
+// has already moved past by the time an OperationHandlerFunc runs.
+func RawRequestFromContext(ctx context.Context) ([]byte, bool) {
+	raw, ok := ctx.Value(rawRequestContextKey{}).([]byte)
+	return raw, ok
+}
+
+// RecoverMiddleware recovers a panic from an inner handler or middleware and
+// turns it into a Receiver fault, so a single bad operation can't take down
+// the whole process.
+func RecoverMiddleware() Middleware {
+	return func(next OperationHandlerFunc) OperationHandlerFunc {
+		return func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (response interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = NewFaultError("Receiver", fmt.Sprintf("panic in operation handler: %v", r))
+				}
+			}()
+			return next(ctx, request, w, httpRequest)
+		}
+	}
+}
+
+// MaxRequestSizeMiddleware rejects a request whose declared Content-Length
+// exceeds maxBytes with a Sender fault. It checks the declared size rather
+// than capping the stream, since ServeHTTP has already read the full body
+// into memory by the time any Middleware runs - wrap the Server in
+// http.MaxBytesReader (or similar) in front of it if you need the read
+// itself capped.
+func MaxRequestSizeMiddleware(maxBytes int64) Middleware {
+	return func(next OperationHandlerFunc) OperationHandlerFunc {
+		return func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			if httpRequest.ContentLength > maxBytes {
+				return nil, NewFaultError("Sender", fmt.Sprintf("request of %d bytes exceeds the %d byte limit", httpRequest.ContentLength, maxBytes))
+			}
+			return next(ctx, request, w, httpRequest)
+		}
+	}
+}
+
+// wsUsernameTokenProbe picks the UsernameToken out of an inbound
+// <wsse:Security> header without needing the rest of the envelope decoded.
+type wsUsernameTokenProbe struct {
+	Header struct {
+		Security struct {
+			UsernameToken struct {
+				Username string `xml:"Username"`
+				Password struct {
+					Type  string `xml:"Type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"Password"`
+			} `xml:"UsernameToken"`
+		} `xml:"Security"`
+	} `xml:"Header"`
+}
+
+// WSUsernameTokenValidator builds a Middleware that rejects a request with a
+// Sender fault unless validate accepts the wsse:Security UsernameToken's
+// username and password. Only PasswordText tokens are supported - a
+// PasswordDigest token is rejected outright, since validating one needs the
+// plaintext password callers of validate don't have. Requires the raw
+// request body in context (see RawRequestFromContext), so it only works
+// wired in via Server.Use or RegisterHandler, not called standalone.
+func WSUsernameTokenValidator(validate func(username, password string) error) Middleware {
+	return func(next OperationHandlerFunc) OperationHandlerFunc {
+		return func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			raw, ok := RawRequestFromContext(ctx)
+			if !ok {
+				return nil, NewFaultError("Sender", "missing WS-Security UsernameToken")
+			}
+			var probe wsUsernameTokenProbe
+			if err := xml.Unmarshal(raw, &probe); err != nil {
+				return nil, NewFaultError("Sender", "could not parse WS-Security header: "+err.Error())
+			}
+			token := probe.Header.Security.UsernameToken
+			if token.Username == "" {
+				return nil, NewFaultError("Sender", "missing WS-Security UsernameToken")
+			}
+			if token.Password.Type == passwordTypeDigest {
+				return nil, NewFaultError("Sender", "PasswordDigest UsernameToken is not supported")
+			}
+			if err := validate(token.Username, token.Password.Value); err != nil {
+				return nil, NewFaultError("Sender", "WS-Security UsernameToken rejected: "+err.Error())
+			}
+			return next(ctx, request, w, httpRequest)
+		}
+	}
+}
+
+// Span is the minimal span interface TracingMiddleware needs. Adapt your
+// tracer of choice - e.g. an OpenTelemetry trace.Tracer/trace.Span pair - to
+// Tracer/Span so this package doesn't have to depend on OpenTelemetry to
+// ship this middleware.
+type Span interface {
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts a Span for one operation call.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TracingMiddleware starts a span around every operation call via tracer,
+// tagged with the request's SOAPAction and the decoded request body's XML
+// QName, and records the handler's error, if any, before ending the span.
+func TracingMiddleware(tracer Tracer) Middleware {
+	return func(next OperationHandlerFunc) OperationHandlerFunc {
+		return func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			soapAction := httpRequest.Header.Get("SOAPAction")
+			spanCtx, span := tracer.StartSpan(ctx, soapAction)
+			span.SetAttribute("soap.action", soapAction)
+			span.SetAttribute("soap.body_qname", requestQName(request))
+			defer span.End()
+			response, err := next(spanCtx, request, w, httpRequest)
+			if err != nil {
+				span.RecordError(err)
+			}
+			return response, err
+		}
+	}
+}
+
+// requestQName returns the decoded request's XMLName if it has one (e.g.
+// "http://example.com/ns Foo"), falling back to its Go type name.
+func requestQName(request interface{}) string {
+	v := reflect.ValueOf(request)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fmt.Sprintf("%T", request)
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprintf("%T", request)
+	}
+	field := v.FieldByName("XMLName")
+	if !field.IsValid() {
+		return fmt.Sprintf("%T", request)
+	}
+	name, ok := field.Interface().(xml.Name)
+	if !ok || name.Local == "" {
+		return fmt.Sprintf("%T", request)
+	}
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + " " + name.Local
+}
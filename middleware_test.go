@@ -0,0 +1,220 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainMiddleware(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next OperationHandlerFunc) OperationHandlerFunc {
+			return func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+				order = append(order, name+":before")
+				resp, err := next(ctx, request, w, httpRequest)
+				order = append(order, name+":after")
+				return resp, err
+			}
+		}
+	}
+	final := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+		order = append(order, "handler")
+		return "ok", nil
+	}
+
+	chained := chainMiddleware(final, []Middleware{trace("outer"), trace("inner")})
+	resp, err := chained(context.Background(), nil, nil, nil)
+	require.NoError(t, err)
+	assert.Exactly(t, "ok", resp)
+	assert.Exactly(t, []string{"outer:before", "inner:before", "handler", "inner:after", "outer:after"}, order)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	panicking := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+		panic("boom")
+	}
+	handler := RecoverMiddleware()(panicking)
+
+	_, err := handler(context.Background(), nil, nil, nil)
+	require.Error(t, err)
+	faultErr, ok := err.(SOAPFaultError)
+	require.True(t, ok)
+	assert.Exactly(t, "Receiver", faultErr.FaultCode())
+	assert.Contains(t, faultErr.FaultReason(), "boom")
+}
+
+func TestMaxRequestSizeMiddleware(t *testing.T) {
+	next := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+		return "ok", nil
+	}
+	handler := MaxRequestSizeMiddleware(10)(next)
+
+	t.Run("under limit", func(t *testing.T) {
+		resp, err := handler(context.Background(), nil, nil, &http.Request{ContentLength: 5})
+		require.NoError(t, err)
+		assert.Exactly(t, "ok", resp)
+	})
+
+	t.Run("over limit", func(t *testing.T) {
+		_, err := handler(context.Background(), nil, nil, &http.Request{ContentLength: 11})
+		require.Error(t, err)
+		faultErr, ok := err.(SOAPFaultError)
+		require.True(t, ok)
+		assert.Exactly(t, "Sender", faultErr.FaultCode())
+	})
+}
+
+func TestWSUsernameTokenValidator(t *testing.T) {
+	next := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+		return "ok", nil
+	}
+
+	const rawOK = `<Envelope><Header><Security><UsernameToken><Username>alice</Username><Password Type="PasswordText">secret</Password></UsernameToken></Security></Header></Envelope>`
+	const rawDigest = `<Envelope><Header><Security><UsernameToken><Username>alice</Username><Password Type="` + passwordTypeDigest + `">deadbeef</Password></UsernameToken></Security></Header></Envelope>`
+	const rawMissing = `<Envelope><Header></Header></Envelope>`
+
+	ctxWithRaw := func(raw string) context.Context {
+		return context.WithValue(context.Background(), rawRequestContextKey{}, []byte(raw))
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		validate := func(username, password string) error {
+			assert.Exactly(t, "alice", username)
+			assert.Exactly(t, "secret", password)
+			return nil
+		}
+		handler := WSUsernameTokenValidator(validate)(next)
+		resp, err := handler(ctxWithRaw(rawOK), nil, nil, nil)
+		require.NoError(t, err)
+		assert.Exactly(t, "ok", resp)
+	})
+
+	t.Run("rejected by validate", func(t *testing.T) {
+		validate := func(username, password string) error { return errors.New("bad credentials") }
+		handler := WSUsernameTokenValidator(validate)(next)
+		_, err := handler(ctxWithRaw(rawOK), nil, nil, nil)
+		require.Error(t, err)
+		faultErr, ok := err.(SOAPFaultError)
+		require.True(t, ok)
+		assert.Exactly(t, "Sender", faultErr.FaultCode())
+		assert.Contains(t, faultErr.FaultReason(), "bad credentials")
+	})
+
+	t.Run("PasswordDigest not supported", func(t *testing.T) {
+		validate := func(username, password string) error {
+			t.Fatal("validate must not be called for a PasswordDigest token")
+			return nil
+		}
+		handler := WSUsernameTokenValidator(validate)(next)
+		_, err := handler(ctxWithRaw(rawDigest), nil, nil, nil)
+		require.Error(t, err)
+		faultErr, ok := err.(SOAPFaultError)
+		require.True(t, ok)
+		assert.Exactly(t, "Sender", faultErr.FaultCode())
+	})
+
+	t.Run("missing UsernameToken", func(t *testing.T) {
+		validate := func(username, password string) error {
+			t.Fatal("validate must not be called without a UsernameToken")
+			return nil
+		}
+		handler := WSUsernameTokenValidator(validate)(next)
+		_, err := handler(ctxWithRaw(rawMissing), nil, nil, nil)
+		require.Error(t, err)
+		faultErr, ok := err.(SOAPFaultError)
+		require.True(t, ok)
+		assert.Exactly(t, "Sender", faultErr.FaultCode())
+	})
+
+	t.Run("missing raw request from context", func(t *testing.T) {
+		validate := func(username, password string) error {
+			t.Fatal("validate must not be called without a raw request")
+			return nil
+		}
+		handler := WSUsernameTokenValidator(validate)(next)
+		_, err := handler(context.Background(), nil, nil, nil)
+		require.Error(t, err)
+		faultErr, ok := err.(SOAPFaultError)
+		require.True(t, ok)
+		assert.Exactly(t, "Sender", faultErr.FaultCode())
+	})
+}
+
+// stubSpan/stubTracer adapt TracingMiddleware's Span/Tracer interfaces
+// without pulling in a real tracing dependency for this test.
+type stubSpan struct {
+	attributes map[string]string
+	errs       []error
+	ended      bool
+}
+
+func (s *stubSpan) SetAttribute(key, value string) { s.attributes[key] = value }
+func (s *stubSpan) RecordError(err error)          { s.errs = append(s.errs, err) }
+func (s *stubSpan) End()                           { s.ended = true }
+
+type stubTracer struct {
+	span *stubSpan
+}
+
+func (t *stubTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.span = &stubSpan{attributes: map[string]string{"name": name}}
+	return ctx, t.span
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	tracer := &stubTracer{}
+
+	t.Run("success", func(t *testing.T) {
+		next := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			return "ok", nil
+		}
+		handler := TracingMiddleware(tracer)(next)
+		httpRequest, err := http.NewRequest("POST", "http://example.com", nil)
+		require.NoError(t, err)
+		httpRequest.Header.Set("SOAPAction", "testAction")
+
+		resp, err := handler(context.Background(), &FooRequest{XMLName: xml.Name{Local: "fooRequest"}, Foo: "hi"}, nil, httpRequest)
+		require.NoError(t, err)
+		assert.Exactly(t, "ok", resp)
+		require.NotNil(t, tracer.span)
+		assert.Exactly(t, "testAction", tracer.span.attributes["soap.action"])
+		assert.Exactly(t, "fooRequest", tracer.span.attributes["soap.body_qname"])
+		assert.True(t, tracer.span.ended)
+		assert.Empty(t, tracer.span.errs)
+	})
+
+	t.Run("records error", func(t *testing.T) {
+		wantErr := NewFaultError("Receiver", "boom")
+		next := func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			return nil, wantErr
+		}
+		handler := TracingMiddleware(tracer)(next)
+		httpRequest, err := http.NewRequest("POST", "http://example.com", nil)
+		require.NoError(t, err)
+
+		_, err = handler(context.Background(), &FooRequest{}, nil, httpRequest)
+		require.Equal(t, wantErr, err)
+		require.Len(t, tracer.span.errs, 1)
+		assert.Equal(t, wantErr, tracer.span.errs[0])
+	})
+}
+
+func TestRequestQName(t *testing.T) {
+	named := &FooRequest{XMLName: xml.Name{Local: "fooRequest"}}
+	assert.Exactly(t, "fooRequest", requestQName(named))
+	assert.Exactly(t, "fooRequest", requestQName(*named))
+
+	// No XMLName set (the zero value) and no XMLName field at all both fall
+	// back to the Go type name.
+	assert.Exactly(t, "*soap.FooRequest", requestQName(&FooRequest{}))
+	assert.Exactly(t, "*soap.FooResponse", requestQName(&FooResponse{}))
+
+	var nilRequest *FooRequest
+	assert.Exactly(t, "*soap.FooRequest", requestQName(nilRequest))
+}
@@ -0,0 +1,434 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+)
+
+// xopNamespace is the XOP namespace an MTOM-inlined field's content is
+// replaced with: <xop:Include href="cid:..."/> in place of its inline text.
+const xopNamespace = "http://www.w3.org/2004/08/xop/include"
+
+// Attachment is a single binary MIME part carried alongside a SOAP envelope
+// for MTOM/XOP transport, instead of being inlined into the XML body.
+type Attachment struct {
+	ContentID   string
+	ContentType string
+	Headers     textproto.MIMEHeader
+	Data        []byte
+}
+
+type mtomAttachmentsContextKey struct{}
+
+// AttachmentsFromContext returns the MTOM/XOP attachment parts carried by
+// the inbound request, if Server.ServeHTTP received a multipart/related;
+// type="application/xop+xml" request. Handlers can use this to stream parts
+// directly instead of going through soap:"mtom" field resolution.
+func AttachmentsFromContext(ctx context.Context) ([]Attachment, bool) {
+	attachments, ok := ctx.Value(mtomAttachmentsContextKey{}).([]Attachment)
+	return attachments, ok
+}
+
+// parseMultipartSOAP splits a multipart/related; type="application/xop+xml"
+// message's body into its SOAP part and its attachment parts, used by both
+// Client (for MTOM responses) and Server (for MTOM requests).
+func parseMultipartSOAP(contentType string, body io.Reader) ([]byte, []Attachment, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	var (
+		soapBytes   []byte
+		foundSoap   bool
+		attachments []Attachment
+	)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		slurp, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !foundSoap && (bytes.HasPrefix(slurp, soapPrefixTagLC) || bytes.HasPrefix(slurp, soapPrefixTagUC)) {
+			soapBytes = slurp
+			foundSoap = true
+			continue
+		}
+		attachments = append(attachments, Attachment{
+			ContentID:   strings.Trim(part.Header.Get("Content-ID"), "<>"),
+			ContentType: part.Header.Get("Content-Type"),
+			Headers:     textproto.MIMEHeader(part.Header),
+			Data:        slurp,
+		})
+	}
+	if !foundSoap {
+		return nil, nil, errors.New("multipart MTOM message does not contain a soapy part")
+	}
+	return soapBytes, attachments, nil
+}
+
+// xmlElementName returns the element name field marshals/unmarshals as: its
+// xml tag's local name if it has one, otherwise its Go field name.
+func xmlElementName(field reflect.StructField) string {
+	tag, ok := field.Tag.Lookup("xml")
+	if !ok {
+		return field.Name
+	}
+	parts := strings.Fields(strings.Split(tag, ",")[0])
+	if len(parts) == 0 {
+		return field.Name
+	}
+	return parts[len(parts)-1]
+}
+
+// resolveMTOMAttachments fills the soap:"mtom"-tagged []byte fields of dest
+// (a pointer to the decoded request struct) from attachments, matching each
+// field to the attachment whose Content-ID is referenced by the
+// <xop:Include href="cid:..."/> found in raw immediately under that field's
+// element.
+func resolveMTOMAttachments(dest interface{}, raw []byte, attachments []Attachment) {
+	rv := reflect.ValueOf(dest)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+
+	hrefs := extractMTOMHrefs(raw)
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("soap") != "mtom" {
+			continue
+		}
+		href, ok := hrefs[xmlElementName(field)]
+		if !ok {
+			continue
+		}
+		contentID := strings.TrimPrefix(href, "cid:")
+		for _, attachment := range attachments {
+			if attachment.ContentID == contentID {
+				rv.Field(i).SetBytes(attachment.Data)
+				break
+			}
+		}
+	}
+}
+
+// extractMTOMHrefs scans raw for <xop:Include href="cid:..."/> elements,
+// keyed by the local name of the element directly enclosing each one - e.g.
+// {"Photo": "cid:image1@example.com"} for
+// <Photo><xop:Include href="cid:image1@example.com"/></Photo>.
+func extractMTOMHrefs(raw []byte) map[string]string {
+	hrefs := map[string]string{}
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	var stack []string
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if el.Name.Local == "Include" && el.Name.Space == xopNamespace {
+				if len(stack) > 0 {
+					for _, attr := range el.Attr {
+						if attr.Name.Local == "href" {
+							hrefs[stack[len(stack)-1]] = attr.Value
+						}
+					}
+				}
+				continue
+			}
+			stack = append(stack, el.Name.Local)
+		case xml.EndElement:
+			if el.Name.Local != "Include" && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return hrefs
+}
+
+// xopIncludeElement is swapped in for a soap:"mtom" field's []byte type (see
+// withXOPIncludes) so that marshaling it emits an <xop:Include href="cid:..."
+// /> in that exact field's position, instead of the field's original
+// content - letting the encoder itself place the substitution, rather than a
+// string search-and-replace over the whole marshaled document that could
+// land on an unrelated element sharing the same name.
+type xopIncludeElement struct {
+	Include struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2004/08/xop/include Include"`
+		Href    string   `xml:"href,attr"`
+	}
+}
+
+// withXOPIncludes returns a pointer to a dynamically built struct, identical
+// to rv's type field-for-field, except each field index in fieldContentIDs
+// has its type swapped to xopIncludeElement carrying that Content-ID - so
+// marshaling the result emits the right <xop:Include> in the right place
+// without ever touching rv or the marshaled bytes.
+func withXOPIncludes(rv reflect.Value, fieldContentIDs map[int]string) reflect.Value {
+	t := rv.Type()
+	var fields []reflect.StructField
+	var srcIndex []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported: invisible to encoding/xml anyway
+			continue
+		}
+		if _, ok := fieldContentIDs[i]; ok {
+			field.Type = reflect.TypeOf(xopIncludeElement{})
+		}
+		fields = append(fields, field)
+		srcIndex = append(srcIndex, i)
+	}
+	nv := reflect.New(reflect.StructOf(fields)).Elem()
+	for newIndex, origIndex := range srcIndex {
+		if contentID, ok := fieldContentIDs[origIndex]; ok {
+			var include xopIncludeElement
+			include.Include.Href = "cid:" + contentID
+			nv.Field(newIndex).Set(reflect.ValueOf(include))
+			continue
+		}
+		nv.Field(newIndex).Set(rv.Field(origIndex))
+	}
+	return nv.Addr()
+}
+
+// writeMTOMResponse marshals responseEnvelope same as the plain path, except
+// any soap:"mtom"-tagged []byte field of response at or above
+// s.MTOMThreshold is pulled out into its own multipart attachment with a
+// generated Content-ID, with its element content replaced by an
+// <xop:Include href="cid:..."/>. It reports false, nil when response has no
+// field crossing the threshold, so the caller can fall back to a plain
+// marshal.
+func (s *Server) writeMTOMResponse(w http.ResponseWriter, responseEnvelope SOAPEnvelope, response interface{}) (bool, error) {
+	rv := reflect.ValueOf(response)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return false, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return false, nil
+	}
+
+	type attachmentPart struct {
+		contentID string
+		data      []byte
+	}
+	var parts []attachmentPart
+	fieldContentIDs := map[int]string{}
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("soap") != "mtom" {
+			continue
+		}
+		fv := rv.Field(i)
+		data, ok := fv.Interface().([]byte)
+		if !ok || len(data) < s.MTOMThreshold {
+			continue
+		}
+		contentID := newUUID() + "@foomo.soap"
+		parts = append(parts, attachmentPart{contentID: contentID, data: data})
+		fieldContentIDs[i] = contentID
+	}
+	if len(parts) == 0 {
+		return false, nil
+	}
+
+	responseEnvelope.SetContent(withXOPIncludes(rv, fieldContentIDs).Interface())
+
+	xmlBytes, err := s.Marshaller.Marshal(responseEnvelope)
+	if err != nil {
+		return true, err
+	}
+
+	const startContentID = "<root.message@foomo.soap>"
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+
+	soapPartHeader := textproto.MIMEHeader{}
+	soapPartHeader.Set("Content-Type", responseEnvelope.ContentType())
+	soapPartHeader.Set("Content-Transfer-Encoding", "8bit")
+	soapPartHeader.Set("Content-ID", startContentID)
+	soapPart, err := mw.CreatePart(soapPartHeader)
+	if err != nil {
+		return true, err
+	}
+	if _, err := soapPart.Write(xmlBytes); err != nil {
+		return true, err
+	}
+
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Transfer-Encoding", "binary")
+		header.Set("Content-ID", "<"+part.contentID+">")
+		partWriter, err := mw.CreatePart(header)
+		if err != nil {
+			return true, err
+		}
+		if _, err := partWriter.Write(part.data); err != nil {
+			return true, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return true, err
+	}
+
+	contentType := fmt.Sprintf(`multipart/related; type="application/xop+xml"; start=%q; start-info="text/xml"; boundary=%q`, startContentID, mw.Boundary())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(multipartBody.Len()))
+	_, err = w.Write(multipartBody.Bytes())
+	return true, err
+}
+
+// writeResponse marshals responseEnvelope and writes it to w, using
+// writeMTOMResponse when s.MTOMThreshold is set and response has a
+// soap:"mtom" field crossing it, and a plain inline marshal otherwise.
+func (s *Server) writeResponse(w http.ResponseWriter, responseEnvelope SOAPEnvelope, response interface{}) error {
+	if s.MTOMThreshold > 0 {
+		if usedMTOM, err := s.writeMTOMResponse(w, responseEnvelope, response); usedMTOM {
+			return err
+		}
+	}
+	xmlBytes, err := s.Marshaller.Marshal(responseEnvelope)
+	if err != nil {
+		return err
+	}
+	addSOAPHeader(w, len(xmlBytes), responseEnvelope.ContentType())
+	w.Write(xmlBytes)
+	return nil
+}
+
+// CallMTOM is like Call, but sends request as the SOAP part of a
+// multipart/related; type="application/xop+xml" message together with
+// attachments, and returns the non-SOAP parts of the response in the order
+// they arrived, each carrying its Content-ID so callers can resolve
+// xop:Include references.
+func (c *Client) CallMTOM(soapAction string, request, response interface{}, attachments []Attachment) ([]Attachment, *http.Response, error) {
+	return c.CallMTOMContext(context.Background(), soapAction, request, response, attachments)
+}
+
+// CallMTOMContext is CallMTOM bound to ctx.
+func (c *Client) CallMTOMContext(ctx context.Context, soapAction string, request, response interface{}, attachments []Attachment) ([]Attachment, *http.Response, error) {
+	envelope := NewEnvelope(c.SoapVersion)
+	envelope.SetContent(request)
+	c.buildCallHeaders(envelope, soapAction)
+
+	soapBytes, err := c.Marshaller.Marshal(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	const startContentID = "<root.message@foomo.soap>"
+
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+
+	soapPartHeader := textproto.MIMEHeader{}
+	soapPartHeader.Set("Content-Type", c.ContentType)
+	soapPartHeader.Set("Content-Transfer-Encoding", "8bit")
+	soapPartHeader.Set("Content-ID", startContentID)
+	soapPart, err := mw.CreatePart(soapPartHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := soapPart.Write(soapBytes); err != nil {
+		return nil, nil, err
+	}
+
+	for _, attachment := range attachments {
+		header := textproto.MIMEHeader{}
+		for key, values := range attachment.Headers {
+			header[key] = values
+		}
+		header.Set("Content-Type", attachment.ContentType)
+		header.Set("Content-Transfer-Encoding", "binary")
+		header.Set("Content-ID", "<"+attachment.ContentID+">")
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := part.Write(attachment.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	contentType := fmt.Sprintf(`multipart/related; type="application/xop+xml"; start=%q; start-info="text/xml"; boundary=%q`, startContentID, mw.Boundary())
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, multipartBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.auth != nil {
+		req.SetBasicAuth(c.auth.Login, c.auth.Password)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", UserAgent)
+	if soapAction != "" {
+		req.Header.Add("SOAPAction", soapAction)
+	}
+	req.Close = true
+
+	c.Log("POST (MTOM) to", c.url, "with", len(attachments), "attachment(s)")
+	httpResponse, err := c.HTTPClientDoFn(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	mediaType, _, err := mime.ParseMediaType(httpResponse.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, httpResponse, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, httpResponse, errors.New("MTOM response is not a multipart message")
+	}
+
+	rawBody, responseAttachments, err := parseMultipartSOAP(httpResponse.Header.Get("Content-Type"), httpResponse.Body)
+	if err != nil {
+		return nil, httpResponse, err
+	}
+
+	respEnvelope := &inboundEnvelope{Body: Body{Content: &dummyContent{}}}
+	if response != nil {
+		respEnvelope.Body = Body{Content: response}
+	}
+	if err := xml.Unmarshal(rawBody, respEnvelope); err != nil {
+		return responseAttachments, httpResponse, fmt.Errorf("soap/mtom.go CallMTOM(): COULD NOT UNMARSHAL: %s\n", err)
+	}
+	if fault := respEnvelope.Body.Fault; fault != nil {
+		return responseAttachments, httpResponse, errors.New("SOAP FAULT:\n" + formatFaultXML(rawBody, 1))
+	}
+
+	return responseAttachments, httpResponse, nil
+}
@@ -0,0 +1,135 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// PhotoRequest has one soap:"mtom" field preceded by an unrelated field that
+// shares the same local element name once marshaled with an empty value -
+// the scenario that broke the old string-replace XOP substitution.
+type PhotoRequest struct {
+	XMLName xml.Name `xml:"photoRequest"`
+	Other   struct {
+		Photo string
+	}
+	Photo []byte `soap:"mtom"`
+}
+
+func TestWriteMTOMResponse_ScopesXOPToTheTaggedField(t *testing.T) {
+	s := NewServer()
+	s.MTOMThreshold = 1
+
+	response := &PhotoRequest{Photo: []byte("binary-data")}
+	envelope := NewEnvelope(SoapVersion11)
+	envelope.SetContent(response)
+
+	rec := httptest.NewRecorder()
+	usedMTOM, err := s.writeMTOMResponse(rec, envelope, response)
+	require.NoError(t, err)
+	require.True(t, usedMTOM)
+
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/related", mediaType)
+
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	soapPart, err := mr.NextPart()
+	require.NoError(t, err)
+	soapBytes, err := ioutil.ReadAll(soapPart)
+	require.NoError(t, err)
+
+	attachmentPart, err := mr.NextPart()
+	require.NoError(t, err)
+	attachmentData, err := ioutil.ReadAll(attachmentPart)
+	require.NoError(t, err)
+	assert.Exactly(t, []byte("binary-data"), attachmentData)
+	contentID := strings.Trim(attachmentPart.Header.Get("Content-ID"), "<>")
+
+	// Decode by structure, not by string search: Other.Photo (empty, no
+	// soap:"mtom" tag) must be untouched, and the xop:Include must land
+	// inside the *tagged* Photo field, not the unrelated one that shares
+	// its local element name.
+	var decoded struct {
+		Body struct {
+			PhotoRequest struct {
+				Other struct {
+					Photo string
+				}
+				Photo struct {
+					Include struct {
+						Href string `xml:"href,attr"`
+					} `xml:"http://www.w3.org/2004/08/xop/include Include"`
+				}
+			} `xml:"photoRequest"`
+		}
+	}
+	require.NoError(t, xml.Unmarshal(soapBytes, &decoded))
+	assert.Empty(t, decoded.Body.PhotoRequest.Other.Photo)
+	assert.Exactly(t, "cid:"+contentID, decoded.Body.PhotoRequest.Photo.Include.Href)
+}
+
+func TestResolveMTOMAttachments(t *testing.T) {
+	raw := []byte(`<photoRequest><Photo><xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="cid:image1@example.com"/></Photo></photoRequest>`)
+	dest := &PhotoRequest{}
+	resolveMTOMAttachments(dest, raw, []Attachment{
+		{ContentID: "image1@example.com", Data: []byte("hello")},
+	})
+	assert.Exactly(t, []byte("hello"), dest.Photo)
+}
+
+func TestCallMTOMContext_AppliesSecurityAndAddressingHeaders(t *testing.T) {
+	c := NewClient("http://localhorst.ch", nil)
+	c.SecurityOptions = []SecurityOption{WithUsernameTokenText("alice", "secret")}
+	c.WSAddressing = true
+
+	var gotEnvelopeXML []byte
+	c.HTTPClientDoFn = (&http.Client{
+		Transport: RoundTrip(func(r *http.Request) (*http.Response, error) {
+			mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+			require.Equal(t, "multipart/related", mediaType)
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			gotEnvelopeXML, err = ioutil.ReadAll(part)
+			require.NoError(t, err)
+
+			buf, mw := createMultiPart(t, []byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><FooResponse><Bar>ok</Bar></FooResponse></soap:Body></soap:Envelope>`))
+			hdr := http.Header{}
+			hdr.Add("Content-Type", mw.FormDataContentType())
+			return &http.Response{StatusCode: 200, Header: hdr, Body: ioutil.NopCloser(buf)}, nil
+		}),
+	}).Do
+
+	req := &FooRequest{Foo: "hello"}
+	var resp FooResponse
+	_, _, err := c.CallMTOMContext(context.Background(), "MySOAPAction", req, &resp, nil)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Header struct {
+			Security struct {
+				UsernameToken struct {
+					Username string
+				}
+			}
+			MessageID string
+			Action    string
+		}
+	}
+	require.NoError(t, xml.Unmarshal(gotEnvelopeXML, &decoded))
+	assert.Exactly(t, "alice", decoded.Header.Security.UsernameToken.Username)
+	assert.NotEmpty(t, decoded.Header.MessageID)
+	assert.Exactly(t, "MySOAPAction", decoded.Header.Action)
+}
@@ -0,0 +1,34 @@
+package soap
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityOptions(t *testing.T) {
+	sec := &Security{MustUnderstand: "1"}
+	WithUsernameTokenDigest("alice", "secret")(sec)
+	WithTimestamp(time.Minute)(sec)
+
+	require.NotNil(t, sec.UsernameToken)
+	assert.Equal(t, "alice", sec.UsernameToken.Username)
+	assert.Equal(t, passwordTypeDigest, sec.UsernameToken.Password.Type)
+	assert.NotEmpty(t, sec.UsernameToken.Password.Value)
+	assert.NotEmpty(t, sec.UsernameToken.Nonce.Value)
+	assert.NotEmpty(t, sec.UsernameToken.Created)
+
+	require.NotNil(t, sec.Timestamp)
+	assert.NotEmpty(t, sec.Timestamp.Created)
+	assert.NotEmpty(t, sec.Timestamp.Expires)
+
+	xmlBytes, err := xml.Marshal(sec)
+	require.NoError(t, err)
+	assert.Contains(t, string(xmlBytes), "<Security")
+	assert.Contains(t, string(xmlBytes), "<UsernameToken")
+	assert.Contains(t, string(xmlBytes), "<Timestamp")
+	assert.Contains(t, string(xmlBytes), namespaceWSSecExt)
+}
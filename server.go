@@ -1,16 +1,23 @@
 package soap
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
-// OperationHandlerFunc runs the actual business logic - request is whatever you constructed in RequestFactoryFunc
-type OperationHandlerFunc func(request interface{}, w http.ResponseWriter, httpRequest *http.Request) (response interface{}, err error)
+// OperationHandlerFunc runs the actual business logic - request is whatever
+// you constructed in RequestFactoryFunc. ctx is derived from httpRequest and
+// carries its cancellation/deadline, so long-running handlers can bail out
+// when the caller goes away.
+type OperationHandlerFunc func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (response interface{}, err error)
 
 // RequestFactoryFunc constructs a request object for OperationHandlerFunc
 type RequestFactoryFunc func() interface{}
@@ -20,6 +27,7 @@ type dummyContent struct{}
 type operationHandler struct {
 	requestFactory RequestFactoryFunc
 	handler        OperationHandlerFunc
+	middleware     []Middleware
 }
 
 type responseWriter struct {
@@ -48,15 +56,45 @@ func (w *responseWriter) WriteHeader(code int) {
 type Server struct {
 	Log         func(...interface{}) // do nothing on nil or add your fmt.Print* or log.*
 	handlers    map[string]map[string]map[string]*operationHandler
+	typeFuncs   map[string]map[string]func(name xml.Name) (reflect.Type, bool)
 	Marshaller  XMLMarshaller
 	ContentType string
 	SoapVersion string
+
+	// WSAddressing, when true, makes ServeHTTP fall back to routing on the
+	// WS-Addressing wsa:Action header when the HTTP SOAPAction header is
+	// absent (as SOAP 1.2 permits), exposes the inbound Addressing via
+	// AddressingFromContext, and adds a generated MessageID to every response
+	// built from a handler's result, plus a RelatesTo correlating back to the
+	// request when the request carried a MessageID. Fault responses carry
+	// neither header. See UseWSAddressing.
+	WSAddressing bool
+
+	// MTOMThreshold, when greater than zero, makes ServeHTTP carry any
+	// soap:"mtom"-tagged []byte response field at or above this many bytes
+	// as its own MTOM/XOP multipart attachment instead of inlining it in the
+	// XML body. Zero (the default) always inlines. See Attachment and
+	// AttachmentsFromContext for the request side, which is always active.
+	MTOMThreshold int
+
+	// FaultMapper, when set, translates an error returned from an
+	// OperationHandlerFunc (or any other error handleError is given) into a
+	// SOAPFaultError, letting it control the rendered fault's code, reason,
+	// detail and actor. Errors that already implement SOAPFaultError bypass
+	// FaultMapper entirely; everything else falls back to a bare Reason
+	// fault if FaultMapper is nil or returns nil. See toSOAPFaultError.
+	FaultMapper func(err error) SOAPFaultError
+
+	// middleware holds global middleware registered via Use, applied to
+	// every operation ahead of whatever RegisterHandler registered for it.
+	middleware []Middleware
 }
 
 // NewServer construct a new SOAP server
 func NewServer() *Server {
 	return &Server{
 		handlers:    make(map[string]map[string]map[string]*operationHandler),
+		typeFuncs:   make(map[string]map[string]func(name xml.Name) (reflect.Type, bool)),
 		Marshaller:  defaultMarshaller{},
 		ContentType: SoapContentType11,
 		SoapVersion: SoapVersion11,
@@ -79,9 +117,16 @@ func (s *Server) UseSoap12() {
 	s.ContentType = SoapContentType12
 }
 
-// RegisterHandler register to handle an operation. This function must not be
-// called after the server has been started.
-func (s *Server) RegisterHandler(path string, action string, messageType string, requestFactory RequestFactoryFunc, operationHandlerFunc OperationHandlerFunc) {
+// UseWSAddressing toggles WS-Addressing support. See the WSAddressing field.
+func (s *Server) UseWSAddressing(enabled bool) {
+	s.WSAddressing = enabled
+}
+
+// RegisterHandler register to handle an operation, optionally wrapping
+// operationHandlerFunc in per-operation middleware (applied inside any
+// global middleware registered via Use). This function must not be called
+// after the server has been started.
+func (s *Server) RegisterHandler(path string, action string, messageType string, requestFactory RequestFactoryFunc, operationHandlerFunc OperationHandlerFunc, middleware ...Middleware) {
 	if _, ok := s.handlers[path]; !ok {
 		s.handlers[path] = make(map[string]map[string]*operationHandler)
 	}
@@ -92,18 +137,61 @@ func (s *Server) RegisterHandler(path string, action string, messageType string,
 	s.handlers[path][action][messageType] = &operationHandler{
 		handler:        operationHandlerFunc,
 		requestFactory: requestFactory,
+		middleware:     middleware,
+	}
+}
+
+// RegisterTypeFunc registers a dynamic request type resolver for path/action,
+// tried before the messageType -> RequestFactoryFunc registered via
+// RegisterHandler. It lets ServeHTTP resolve the Body's content type straight
+// off the wire's first element xml.Name in a single decoding pass, instead of
+// unmarshalling the envelope once to probe the type and again to decode the
+// real request - useful when a single action can carry more than one request
+// shape. The type it resolves still needs a matching messageType registered
+// via RegisterHandler, since that's what picks the OperationHandlerFunc to
+// run. This function must not be called after the server has been started.
+func (s *Server) RegisterTypeFunc(path string, action string, typeFunc func(name xml.Name) (reflect.Type, bool)) {
+	if _, ok := s.typeFuncs[path]; !ok {
+		s.typeFuncs[path] = make(map[string]func(name xml.Name) (reflect.Type, bool))
 	}
+	s.typeFuncs[path][action] = typeFunc
 }
 
-func (s *Server) handleError(err error, w http.ResponseWriter) {
-	// has to write a soap fault
+// handleError writes a SOAP fault in the given soapVersion, so a server that
+// accepts both SOAP 1.1 and 1.2 on the same path replies in the version the
+// request came in as, rather than whatever UseSoap11/UseSoap12 last set. err
+// is rendered through toSOAPFaultError, so it can carry a fault code, 1.2
+// subcodes, a detail element and (1.1 only) an actor - either by implementing
+// SOAPFaultError itself or via s.FaultMapper - and the HTTP status reflects
+// the fault's code: 400 for Sender/Client, 500 (the default) otherwise.
+func (s *Server) handleError(err error, w http.ResponseWriter, soapVersion string) {
 	s.log("handling error:", err)
-	responseEnvelope := &Envelope{
-		Body: Body{
-			Content: &Fault{
-				String: err.Error(),
-			},
-		},
+	faultErr := s.toSOAPFaultError(err)
+	code := normalizeFaultCode(faultErr.FaultCode(), soapVersion)
+	responseEnvelope := NewEnvelope(soapVersion)
+	statusCode := http.StatusInternalServerError
+	if soapVersion == SoapVersion12 {
+		if code == "" {
+			code = "Receiver"
+		}
+		if code == "Sender" {
+			statusCode = http.StatusBadRequest
+		}
+		responseEnvelope.SetContent(&Fault12{
+			Code:   Fault12Code{Value: code, Subcode: chainFault12Subcodes(faultErr.FaultSubcodes())},
+			Reason: Fault12Reason{Text: faultErr.FaultReason()},
+			Detail: marshalFaultDetail(faultErr.FaultDetail()),
+		})
+	} else {
+		if code == "Client" {
+			statusCode = http.StatusBadRequest
+		}
+		responseEnvelope.SetContent(&Fault11{
+			Code:   code,
+			String: faultErr.FaultReason(),
+			Actor:  faultErr.FaultActor(),
+			Detail: marshalFaultDetail(faultErr.FaultDetail()),
+		})
 	}
 	xmlBytes, xmlErr := s.Marshaller.Marshal(responseEnvelope)
 	if xmlErr != nil {
@@ -111,10 +199,33 @@ func (s *Server) handleError(err error, w http.ResponseWriter) {
 		fmt.Fprintf(w, "could not marshal soap fault for: %s xmlError: %s\n", err, xmlErr)
 		return
 	}
-	addSOAPHeader(w, len(xmlBytes), s.ContentType)
+	addSOAPHeader(w, len(xmlBytes), responseEnvelope.ContentType())
+	w.WriteHeader(statusCode)
 	w.Write(xmlBytes)
 }
 
+// detectSoapVersion determines the SOAP version of an inbound request from
+// its Content-Type header (SOAP 1.2 uses application/soap+xml, 1.1 uses
+// text/xml), falling back to fallback when the header is missing or doesn't
+// say either way. ServeHTTP refines this further once the envelope itself
+// has been decoded, using the root element's namespace - this combination is
+// what lets a single Server accept SOAP 1.1 and 1.2 simultaneously on the
+// same path and reply in kind, instead of being pinned to one SoapVersion.
+func detectSoapVersion(contentType string, fallback string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fallback
+	}
+	switch mediaType {
+	case SoapContentTypeMedia12:
+		return SoapVersion12
+	case SoapContentTypeMedia11:
+		return SoapVersion11
+	default:
+		return fallback
+	}
+}
+
 // WriteHeader first set the content-type header and then writes the header code.
 func (s *Server) WriteHeader(w http.ResponseWriter, code int) {
 	setContentType(w, s.ContentType)
@@ -139,94 +250,148 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w:             w,
 		outputStarted: false,
 	}
+	// Picked from the Content-Type header for now; refined below once the
+	// envelope is decoded and we know its root namespace for certain. This is
+	// also what default (non-POST) error responses use, since there's no
+	// body to refine it from.
+	soapVersion := detectSoapVersion(r.Header.Get("Content-Type"), s.SoapVersion)
 	switch r.Method {
 	case "POST":
-		soapRequestBytes, err := ioutil.ReadAll(r.Body)
-		// Our structs for Envelope, Header, Body and Fault are tagged with namespace for SOAP 1.1
-		// Therefore we must adjust namespaces for incoming SOAP 1.2 messages
-		if s.SoapVersion == SoapVersion12 {
-			soapRequestBytes = replaceSoap12to11(soapRequestBytes)
+		var (
+			soapRequestBytes []byte
+			attachments      []Attachment
+			err              error
+		)
+		if mediaType, _, mediaErr := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaErr == nil && strings.HasPrefix(mediaType, "multipart/") {
+			soapRequestBytes, attachments, err = parseMultipartSOAP(r.Header.Get("Content-Type"), r.Body)
+		} else {
+			soapRequestBytes, err = ioutil.ReadAll(r.Body)
 		}
-
 		if err != nil {
-			s.handleError(fmt.Errorf("could not read POST:: %s", err), w)
+			s.handleError(fmt.Errorf("could not read POST:: %s", err), w, soapVersion)
 			return
 		}
+
+		// SOAP 1.2 makes the HTTP SOAPAction header optional, so fall back to
+		// routing on the WS-Addressing wsa:Action header when it's absent; we
+		// also read MessageID/To/ReplyTo/RelatesTo here so they can be handed
+		// to the handler and echoed back as RelatesTo.
+		var wsaHeader wsaHeaderProbe
+		if s.WSAddressing {
+			_ = xml.Unmarshal(soapRequestBytes, &wsaHeader)
+			if soapAction == "" {
+				soapAction = wsaHeader.Header.Action
+			}
+		}
+
 		pathHandlers, pathHandlerOK := s.handlers[r.URL.Path]
 		if !pathHandlerOK {
-			s.handleError(fmt.Errorf("unknown path %q", r.URL.Path), w)
+			s.handleError(fmt.Errorf("unknown path %q", r.URL.Path), w, soapVersion)
 			return
 		}
 		actionHandlers, ok := pathHandlers[soapAction]
 		if !ok {
-			s.handleError(fmt.Errorf("unknown action %q", soapAction), w)
+			s.handleError(fmt.Errorf("unknown action %q", soapAction), w, soapVersion)
 			return
 		}
+		typeFunc := s.typeFuncs[r.URL.Path][soapAction]
 
-		// we need to find out, what is in the body
-		probeEnvelope := &Envelope{
+		// Single-pass decode: Body.UnmarshalXML calls this with the Body's
+		// first child element's xml.Name and decodes straight into whatever
+		// type it resolves, so we no longer have to unmarshal the envelope
+		// once to probe the content type and a second time to decode the
+		// real request.
+		var messageType string
+		envelope := &inboundEnvelope{
 			Body: Body{
-				Content: &dummyContent{},
+				TypeFunc: func(name xml.Name) (reflect.Type, bool) {
+					messageType = name.Local
+					if typeFunc != nil {
+						if t, ok := typeFunc(name); ok {
+							return t, true
+						}
+					}
+					actionHandler, ok := actionHandlers[name.Local]
+					if !ok {
+						// No handler registered for this message type either -
+						// decode into a throwaway type rather than failing the
+						// unmarshal outright, so the actionHandlers lookup
+						// below still gets to report the friendlier "no action
+						// handler for content type" fault instead of a raw
+						// decode error.
+						return reflect.TypeOf(dummyContent{}), true
+					}
+					return reflect.TypeOf(actionHandler.requestFactory()).Elem(), true
+				},
 			},
 		}
 
-		err = s.Marshaller.Unmarshal(soapRequestBytes, probeEnvelope)
+		err = xml.Unmarshal(soapRequestBytes, envelope)
 		if err != nil {
-			s.handleError(fmt.Errorf("could not probe soap body content:: %s", err), w)
+			s.handleError(fmt.Errorf("could not unmarshal request:: %s", err), w, soapVersion)
 			return
 		}
-		t := probeEnvelope.Body.SOAPBodyContentType
-		s.log("found content type", t)
-		actionHandler, ok := actionHandlers[t]
+		// The root element's namespace is ground truth for the version on
+		// the wire, unlike Content-Type, which servers sometimes get wrong.
+		if envelope.XMLName.Space == NamespaceSoap12 {
+			soapVersion = SoapVersion12
+		} else if envelope.XMLName.Space == NamespaceSoap11 {
+			soapVersion = SoapVersion11
+		}
+		s.log("found content type", messageType)
+		actionHandler, ok := actionHandlers[messageType]
 		if !ok {
-			s.handleError(fmt.Errorf("no action handler for content type: %q", t), w)
+			s.handleError(fmt.Errorf("no action handler for content type: %q", messageType), w, soapVersion)
 			return
 		}
-		request := actionHandler.requestFactory()
-		envelope := &Envelope{
-			Header: Header{},
-			Body: Body{
-				Content: request,
-			},
+		request := envelope.DecodedBody()
+		if len(attachments) > 0 {
+			resolveMTOMAttachments(request, soapRequestBytes, attachments)
 		}
+		s.log("request", s.jsonDump(envelope))
 
-		err = xml.Unmarshal(soapRequestBytes, &envelope)
-		if err != nil {
-			s.handleError(fmt.Errorf("could not unmarshal request:: %s", err), w)
-			return
+		ctx := context.WithValue(r.Context(), rawRequestContextKey{}, soapRequestBytes)
+		if s.WSAddressing {
+			ctx = context.WithValue(ctx, addressingContextKey{}, Addressing{
+				MessageID: wsaHeader.Header.MessageID,
+				To:        wsaHeader.Header.To,
+				ReplyTo:   wsaHeader.Header.ReplyTo.Address,
+				RelatesTo: wsaHeader.Header.RelatesTo,
+			})
 		}
-		s.log("request", s.jsonDump(envelope))
+		if len(attachments) > 0 {
+			ctx = context.WithValue(ctx, mtomAttachmentsContextKey{}, attachments)
+		}
+		r = r.WithContext(ctx)
 
-		response, err := actionHandler.handler(request, w, r)
+		handler := chainMiddleware(actionHandler.handler, actionHandler.middleware)
+		handler = chainMiddleware(handler, s.middleware)
+		response, err := handler(ctx, request, w, r)
 		if err != nil {
 			s.log("action handler threw up")
-			s.handleError(err, w)
+			s.handleError(err, w, soapVersion)
 			return
 		}
 		s.log("result", s.jsonDump(response))
 		if !w.(*responseWriter).outputStarted {
-			responseEnvelope := &Envelope{
-				Body: Body{
-					Content: response,
-				},
-			}
-			xmlBytes, err := s.Marshaller.Marshal(responseEnvelope)
-			// Adjust namespaces for SOAP 1.2
-			if s.SoapVersion == SoapVersion12 {
-				xmlBytes = replaceSoap11to12(xmlBytes)
+			responseEnvelope := NewEnvelope(soapVersion)
+			responseEnvelope.SetContent(response)
+			if s.WSAddressing {
+				responseEnvelope.AddHeader(&messageID{Value: newMessageID()})
+				if wsaHeader.Header.MessageID != "" {
+					responseEnvelope.AddHeader(&RelatesTo{Value: wsaHeader.Header.MessageID})
+				}
 			}
-			if err != nil {
-				s.handleError(fmt.Errorf("could not marshal response:: %s", err), w)
+			if err := s.writeResponse(w, responseEnvelope, response); err != nil {
+				s.handleError(fmt.Errorf("could not marshal response:: %s", err), w, soapVersion)
 			}
-			addSOAPHeader(w, len(xmlBytes), s.ContentType)
-			w.Write(xmlBytes)
 		} else {
 			s.log("action handler sent its own output")
 		}
 
 	default:
 		// this will be a soap fault !?
-		s.handleError(errors.New("this is a soap service - you have to POST soap requests"), w)
+		s.handleError(errors.New("this is a soap service - you have to POST soap requests"), w, soapVersion)
 	}
 }
 
@@ -2,10 +2,12 @@ package soap
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,7 +24,7 @@ func TestServer_ServeHTTP(t *testing.T) {
 		func() interface{} {
 			return &FooRequest{}
 		},
-		func(request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+		func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
 			fooRequest := request.(*FooRequest)
 			return &FooResponse{
 				Bar: "Hello \"" + fooRequest.Foo + "\"",
@@ -83,6 +85,88 @@ func TestServer_ServeHTTP(t *testing.T) {
 		}
 
 		require.NoError(t, xml.NewDecoder(resp.Body).Decode(responseEnvelope))
-		assert.Exactly(t, "no action handler for content type: \"barRequest\"", responseEnvelope.Body.Fault.String)
+		assert.Exactly(t, "no action handler for content type: \"barRequest\"", responseEnvelope.Body.Fault.Error())
+	})
+}
+
+func TestServer_ServeHTTP_RegisterTypeFunc(t *testing.T) {
+	type BarRequest struct {
+		XMLName xml.Name `xml:"barRequest"`
+		Baz     string
+	}
+
+	soapSrv := NewServer()
+	soapSrv.UseSoap11()
+	soapSrv.RegisterTypeFunc("/pathTo", "testPostAction", func(name xml.Name) (reflect.Type, bool) {
+		switch name.Local {
+		case "fooRequest":
+			return reflect.TypeOf(FooRequest{}), true
+		case "barRequest":
+			return reflect.TypeOf(BarRequest{}), true
+		}
+		return nil, false
+	})
+	soapSrv.RegisterHandler(
+		"/pathTo", "testPostAction", "fooRequest",
+		func() interface{} { return &FooRequest{} },
+		func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			return &FooResponse{Bar: "Hello " + request.(*FooRequest).Foo}, nil
+		},
+	)
+	soapSrv.RegisterHandler(
+		"/pathTo", "testPostAction", "barRequest",
+		func() interface{} { return &BarRequest{} },
+		func(ctx context.Context, request interface{}, w http.ResponseWriter, httpRequest *http.Request) (interface{}, error) {
+			return &FooResponse{Bar: "Hi " + request.(*BarRequest).Baz}, nil
+		},
+	)
+	srv := httptest.NewServer(soapSrv)
+	defer srv.Close()
+
+	postFn := func(t *testing.T, postBody []byte) *http.Response {
+		body := ioutil.NopCloser(bytes.NewReader(postBody))
+		req, err := http.NewRequest("POST", srv.URL+"/pathTo", body)
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", SoapContentType11)
+		req.Header.Add("SOAPAction", "testPostAction")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("dispatches fooRequest via the registered TypeFunc", func(t *testing.T) {
+		resp := postFn(t, []byte(`<SOAP:Envelope xmlns:SOAP="http://schemas.xmlsoap.org/soap/envelope/">
+    <Header xmlns="http://schemas.xmlsoap.org/soap/envelope/"></Header>
+    <Body xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+        <fooRequest><Foo>i am foo</Foo></fooRequest>
+    </Body>
+</SOAP:Envelope>`))
+		responseEnvelope := &Envelope{Body: Body{Content: &FooResponse{}}}
+		require.NoError(t, xml.NewDecoder(resp.Body).Decode(responseEnvelope))
+		assert.Exactly(t, "Hello i am foo", responseEnvelope.Body.Content.(*FooResponse).Bar)
+	})
+
+	t.Run("dispatches barRequest via the same TypeFunc", func(t *testing.T) {
+		resp := postFn(t, []byte(`<SOAP:Envelope xmlns:SOAP="http://schemas.xmlsoap.org/soap/envelope/">
+    <Header xmlns="http://schemas.xmlsoap.org/soap/envelope/"></Header>
+    <Body xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+        <barRequest><Baz>i am bar</Baz></barRequest>
+    </Body>
+</SOAP:Envelope>`))
+		responseEnvelope := &Envelope{Body: Body{Content: &FooResponse{}}}
+		require.NoError(t, xml.NewDecoder(resp.Body).Decode(responseEnvelope))
+		assert.Exactly(t, "Hi i am bar", responseEnvelope.Body.Content.(*FooResponse).Bar)
+	})
+
+	t.Run("message type unknown to both TypeFunc and RegisterHandler still reports the structured fault", func(t *testing.T) {
+		resp := postFn(t, []byte(`<SOAP:Envelope xmlns:SOAP="http://schemas.xmlsoap.org/soap/envelope/">
+    <Header xmlns="http://schemas.xmlsoap.org/soap/envelope/"></Header>
+    <Body xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+        <bazRequest><Foo>i am foo</Foo></bazRequest>
+    </Body>
+</SOAP:Envelope>`))
+		responseEnvelope := &Envelope{Body: Body{Content: &dummyContent{}}}
+		require.NoError(t, xml.NewDecoder(resp.Body).Decode(responseEnvelope))
+		assert.Exactly(t, "no action handler for content type: \"bazRequest\"", responseEnvelope.Body.Fault.Error())
 	})
 }
@@ -2,6 +2,7 @@ package soap
 
 import (
 	"encoding/xml"
+	"reflect"
 )
 
 // SOAP 1.1 and SOAP 1.2 must expect different ContentTypes and Namespaces.
@@ -13,52 +14,199 @@ const (
 	SoapContentType11 = "text/xml; charset=\"utf-8\""
 	SoapContentType12 = "application/soap+xml; charset=\"utf-8\""
 
+	// SoapContentTypeMedia11/12 are SoapContentType11/12 without the charset
+	// parameter, i.e. what mime.ParseMediaType returns for either - used to
+	// detect an inbound request's SOAP version from its Content-Type header.
+	SoapContentTypeMedia11 = "text/xml"
+	SoapContentTypeMedia12 = "application/soap+xml"
+
 	NamespaceSoap11 = "http://schemas.xmlsoap.org/soap/envelope/"
 	NamespaceSoap12 = "http://www.w3.org/2003/05/soap-envelope"
 )
 
-var (
-	bNamespaceSoap11 = []byte("http://schemas.xmlsoap.org/soap/envelope/")
-	bNamespaceSoap12 = []byte("http://www.w3.org/2003/05/soap-envelope")
+// SOAPEnvelope is implemented by the version-specific envelope types so
+// Client and Server can build and marshal a request or response without
+// caring which SOAP version is in play.
+type SOAPEnvelope interface {
+	// SetHeader replaces the header block wholesale.
+	SetHeader(headers ...interface{})
+	// AddHeader appends one or more elements to the header block, leaving
+	// anything already there (e.g. WS-Security, WS-Addressing) in place.
+	AddHeader(headers ...interface{})
+	SetContent(content interface{})
+	GetBody() *Body
+	ContentType() string
+
+	// DecodedBody returns whatever ended up in the Body, be that a
+	// preassigned Content value or one resolved through Body.TypeFunc while
+	// unmarshalling.
+	DecodedBody() interface{}
+}
 
+var (
+	_ SOAPEnvelope = (*Envelope11)(nil)
+	_ SOAPEnvelope = (*Envelope12)(nil)
 )
 
-// Envelope type `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-type Envelope struct {
+// NewEnvelope builds the envelope matching soapVersion (SoapVersion11 or
+// SoapVersion12), defaulting to SOAP 1.1 for anything else.
+func NewEnvelope(soapVersion string) SOAPEnvelope {
+	if soapVersion == SoapVersion12 {
+		return &Envelope12{}
+	}
+	return &Envelope11{}
+}
+
+// Envelope11 is a SOAP 1.1 envelope.
+type Envelope11 struct {
 	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-	Header  Header
+	Header  Header11
 	Body    Body
 }
 
-// Header type
-type Header struct {
+func (e *Envelope11) SetHeader(headers ...interface{}) { e.Header.Header = headers }
+func (e *Envelope11) AddHeader(headers ...interface{}) {
+	e.Header.Header = append(e.Header.Header, headers...)
+}
+func (e *Envelope11) SetContent(content interface{}) { e.Body.Content = content }
+func (e *Envelope11) GetBody() *Body                 { return &e.Body }
+func (e *Envelope11) ContentType() string            { return SoapContentType11 }
+func (e *Envelope11) DecodedBody() interface{}       { return e.Body.Content }
+
+// Header11 is a SOAP 1.1 header. Header holds zero or more independently
+// marshallable elements (WS-Security, WS-Addressing, ...), each responsible
+// for its own XMLName.
+type Header11 struct {
 	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Header"`
 
-	Header interface{}
+	Header []interface{}
+}
+
+// Envelope12 is a SOAP 1.2 envelope.
+type Envelope12 struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2003/05/soap-envelope Envelope"`
+	Header  Header12
+	Body    Body
+}
+
+func (e *Envelope12) SetHeader(headers ...interface{}) { e.Header.Header = headers }
+func (e *Envelope12) AddHeader(headers ...interface{}) {
+	e.Header.Header = append(e.Header.Header, headers...)
+}
+func (e *Envelope12) SetContent(content interface{}) { e.Body.Content = content }
+func (e *Envelope12) GetBody() *Body                 { return &e.Body }
+func (e *Envelope12) ContentType() string            { return SoapContentType12 }
+func (e *Envelope12) DecodedBody() interface{}       { return e.Body.Content }
+
+// Header12 is a SOAP 1.2 header. See Header11 for the content model.
+type Header12 struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2003/05/soap-envelope Header"`
+
+	Header []interface{}
 }
 
+// Envelope is kept as an alias of Envelope11 for code that only ever dealt
+// with SOAP 1.1.
+type Envelope = Envelope11
+
+// Header is kept as an alias of Header11 for code that only ever dealt with
+// SOAP 1.1.
+type Header = Header11
+
+// inboundEnvelope unmarshals a response or request without pinning its
+// Envelope/Header to one SOAP version: 1.1 and 1.2 differ only in the outer
+// namespace, and encoding/xml rejects a namespaced XMLName that doesn't match
+// what's on the wire, so we deliberately leave it unset here and let Body
+// tell 1.1 and 1.2 faults apart on its own.
+type inboundEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Header  struct {
+		XMLName xml.Name `xml:"Header"`
+		Header  interface{}
+	}
+	Body Body
+}
+
+// DecodedBody returns whatever ended up in the Body.
+func (e *inboundEnvelope) DecodedBody() interface{} { return e.Body.Content }
+
 // Body type
 type Body struct {
-	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	XMLName xml.Name `xml:"Body"`
 
-	Fault               *Fault      `xml:",omitempty"`
-	Content             interface{} `xml:",omitempty"`
-	SOAPBodyContentType string      `xml:"-"`
+	Fault   error       `xml:",omitempty"`
+	Content interface{} `xml:",omitempty"`
+
+	// TypeFunc lets Content be resolved while unmarshalling instead of being
+	// preallocated by the caller: when Content is nil, UnmarshalXML looks up
+	// the first element's xml.Name here, reflect.New()s the returned type and
+	// decodes into that. Useful for operations that can return more than one
+	// response shape.
+	TypeFunc func(name xml.Name) (reflect.Type, bool) `xml:"-"`
+
+	SOAPBodyContentType string `xml:"-"`
 }
 
-// Fault type
-type Fault struct {
+// Fault11 is a SOAP 1.1 fault.
+type Fault11 struct {
 	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
 
-	Code   string `xml:"faultcode,omitempty"`
-	String string `xml:"faultstring,omitempty"`
-	Actor  string `xml:"faultactor,omitempty"`
-	Detail string `xml:"detail,omitempty"`
+	Code   string       `xml:"faultcode,omitempty"`
+	String string       `xml:"faultstring,omitempty"`
+	Actor  string       `xml:"faultactor,omitempty"`
+	Detail *FaultDetail `xml:"detail,omitempty"`
+}
+
+func (f *Fault11) Error() string { return f.String }
+
+// Fault12 is a SOAP 1.2 fault. Unlike 1.1, the code is a structured
+// Value/Subcode pair and the human-readable message lives under Reason.
+type Fault12 struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2003/05/soap-envelope Fault"`
+
+	Code   Fault12Code   `xml:"Code"`
+	Reason Fault12Reason `xml:"Reason"`
+	Node   string        `xml:"Node,omitempty"`
+	Role   string        `xml:"Role,omitempty"`
+	Detail *FaultDetail  `xml:"Detail,omitempty"`
+}
+
+// Fault12Code is the SOAP 1.2 fault code, e.g. Sender or Receiver, optionally
+// refined by a Subcode.
+type Fault12Code struct {
+	Value   string          `xml:"Value"`
+	Subcode *Fault12Subcode `xml:"Subcode,omitempty"`
+}
+
+// Fault12Subcode refines a Fault12Code (or its enclosing Subcode) with an
+// application-specific value, nestable to any depth per the WS-I spec.
+type Fault12Subcode struct {
+	Value   string          `xml:"Value"`
+	Subcode *Fault12Subcode `xml:"Subcode,omitempty"`
+}
+
+// Fault12Reason carries the human-readable fault text for SOAP 1.2.
+type Fault12Reason struct {
+	Text string `xml:"Text"`
 }
 
+func (f *Fault12) Error() string { return f.Reason.Text }
+
+// FaultDetail carries a Fault's detail/Detail element as raw inner XML, so
+// it can hold an arbitrary, application-specific shape - marshaled verbatim
+// from whatever SOAPFaultError.FaultDetail returns, and left as-is on
+// unmarshal for the caller to decode into their own type if they know it.
+type FaultDetail struct {
+	RawXML []byte `xml:",innerxml"`
+}
+
+// Fault is kept as an alias of Fault11 for code that only ever dealt with
+// SOAP 1.1.
+type Fault = Fault11
+
 // UnmarshalXML implement xml.Unmarshaler
 func (b *Body) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	if b.Content == nil {
+	if b.Content == nil && b.TypeFunc == nil {
 		return xml.UnmarshalError("Content must be a pointer to a struct")
 	}
 
@@ -82,18 +230,34 @@ Loop:
 		case xml.StartElement:
 			if consumed {
 				return xml.UnmarshalError("Found multiple elements inside SOAP body; not wrapped-document/literal WS-I compliant")
-			} else if se.Name.Space == "http://schemas.xmlsoap.org/soap/envelope/" && se.Name.Local == "Fault" {
-				b.Fault = &Fault{}
-				b.Content = nil
-
-				err = d.DecodeElement(b.Fault, &se)
-				if err != nil {
-					return err
+			} else if se.Name.Local == "Fault" && (se.Name.Space == NamespaceSoap11 || se.Name.Space == NamespaceSoap12) {
+				var fault error
+				if se.Name.Space == NamespaceSoap12 {
+					f12 := &Fault12{}
+					if err = d.DecodeElement(f12, &se); err != nil {
+						return err
+					}
+					fault = f12
+				} else {
+					f11 := &Fault11{}
+					if err = d.DecodeElement(f11, &se); err != nil {
+						return err
+					}
+					fault = f11
 				}
+				b.Fault = fault
+				b.Content = nil
 
 				consumed = true
 			} else {
 				b.SOAPBodyContentType = se.Name.Local
+				if b.Content == nil {
+					t, ok := b.TypeFunc(se.Name)
+					if !ok {
+						return xml.UnmarshalError("no type registered for element " + se.Name.Local)
+					}
+					b.Content = reflect.New(t).Interface()
+				}
 				if err = d.DecodeElement(b.Content, &se); err != nil {
 					return err
 				}
@@ -107,7 +271,3 @@ Loop:
 
 	return nil
 }
-
-func (f *Fault) Error() string {
-	return f.String
-}
@@ -0,0 +1,104 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBody_UnmarshalXML_TypeFunc(t *testing.T) {
+	rawEnvelope := []byte(`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+	<Header></Header>
+	<Body>
+		<fooRequest><Foo>hi</Foo></fooRequest>
+	</Body>
+</Envelope>`)
+
+	t.Run("resolves the type from the first element's xml.Name", func(t *testing.T) {
+		envelope := &Envelope{
+			Body: Body{
+				TypeFunc: func(name xml.Name) (reflect.Type, bool) {
+					if name.Local == "fooRequest" {
+						return reflect.TypeOf(FooRequest{}), true
+					}
+					return nil, false
+				},
+			},
+		}
+		require.NoError(t, xml.Unmarshal(rawEnvelope, envelope))
+		decoded, ok := envelope.DecodedBody().(*FooRequest)
+		require.True(t, ok)
+		assert.Exactly(t, "hi", decoded.Foo)
+	})
+
+	t.Run("no match surfaces as an unmarshal error", func(t *testing.T) {
+		envelope := &Envelope{
+			Body: Body{
+				TypeFunc: func(name xml.Name) (reflect.Type, bool) {
+					return nil, false
+				},
+			},
+		}
+		err := xml.Unmarshal(rawEnvelope, envelope)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no type registered for element fooRequest")
+	})
+}
+
+func TestClient_CallTyped(t *testing.T) {
+	httpSOAPResponse := []byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+	<soap:Body>
+		<FooResponse><Bar>hello</Bar></FooResponse>
+	</soap:Body>
+</soap:Envelope>`)
+
+	c := NewClient("http://localhorst.ch", nil)
+	c.TypeFunc = func(name xml.Name) (reflect.Type, bool) {
+		if name.Local == "FooResponse" {
+			return reflect.TypeOf(FooResponse{}), true
+		}
+		return nil, false
+	}
+	c.HTTPClientDoFn = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(httpSOAPResponse)),
+		}, nil
+	}
+
+	decoded, _, err := c.CallTyped(context.Background(), "MySOAPAction", &FooRequest{Foo: "hi"})
+	require.NoError(t, err)
+	resp, ok := decoded.(*FooResponse)
+	require.True(t, ok)
+	assert.Exactly(t, "hello", resp.Bar)
+}
+
+func TestClient_CallTyped_NoMatch(t *testing.T) {
+	httpSOAPResponse := []byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+	<soap:Body>
+		<BarResponse><Baz>hello</Baz></BarResponse>
+	</soap:Body>
+</soap:Envelope>`)
+
+	c := NewClient("http://localhorst.ch", nil)
+	c.TypeFunc = func(name xml.Name) (reflect.Type, bool) {
+		return nil, false
+	}
+	c.HTTPClientDoFn = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(httpSOAPResponse)),
+		}, nil
+	}
+
+	_, _, err := c.CallTyped(context.Background(), "MySOAPAction", &FooRequest{Foo: "hi"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no type registered for element BarResponse")
+}